@@ -0,0 +1,92 @@
+/*
+BSD 3-Clause License
+
+Copyright (c) 2018, Psiphon Inc.
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+* Redistributions of source code must retain the above copyright notice, this
+  list of conditions and the following disclaimer.
+
+* Redistributions in binary form must reproduce the above copyright notice,
+  this list of conditions and the following disclaimer in the documentation
+  and/or other materials provided with the distribution.
+
+* Neither the name of the copyright holder nor the names of its
+  contributors may be used to endorse or promote products derived from
+  this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package multoml
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewFromDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "multoml-dir-test")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir failed: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeFile(t, dir, "10-base.toml", "port = 1\nname = \"base\"\n")
+	writeFile(t, dir, "20-site.toml", "name = \"site\"\n")
+	writeFile(t, dir, "zz.override.toml", "port = 2\n")
+
+	if err := os.Mkdir(filepath.Join(dir, overrideDirName), 0755); err != nil {
+		t.Fatalf("os.Mkdir failed: %v", err)
+	}
+	writeFile(t, filepath.Join(dir, overrideDirName), "host.toml", "name = \"host\"\n")
+
+	conf, filesUsed, err := NewFromDir(dir, nil)
+	if err != nil {
+		t.Fatalf("NewFromDir() error = %v", err)
+	}
+
+	if len(filesUsed) != 4 {
+		t.Errorf("filesUsed = %v, want 4 entries", filesUsed)
+	}
+
+	if got := conf.Get("port"); got != int64(2) {
+		t.Errorf("port = %v, want 2", got)
+	}
+	if got := conf.Get("name"); got != "host" {
+		t.Errorf("name = %v, want host", got)
+	}
+}
+
+func TestNewFromDir_NoTomlFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "multoml-dir-test-empty")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir failed: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if _, _, err := NewFromDir(dir, nil); err == nil {
+		t.Errorf("NewFromDir() expected an error for a directory with no *.toml files")
+	}
+}
+
+func writeFile(t *testing.T, dir, name, contents string) {
+	t.Helper()
+	if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0644); err != nil {
+		t.Fatalf("ioutil.WriteFile failed for %s: %v", name, err)
+	}
+}
@@ -0,0 +1,179 @@
+/*
+ * BSD 3-Clause License
+ * Copyright (c) 2018, Psiphon Inc.
+ * All rights reserved.
+ */
+
+package multoml
+
+import (
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// envArraySeparator splits an environment variable's value into elements when it's
+// overriding a TOML array. There's no way to represent a literal comma in an element
+// this way; that's an accepted limitation of the env-var override mechanism.
+const envArraySeparator = ","
+
+// EnvOverrides maps a dotted TOML config key (e.g. "database.host") to an ordered list
+// of candidate environment variable names that may supply its value. The candidates are
+// checked in written order and the first one that is set wins. The value found is
+// coerced to the type already present at that key (int64, float64, bool, time.Time, or a
+// slice of any of those, split on envArraySeparator); if the key doesn't already exist,
+// it's left as a string.
+type EnvOverrides map[string][]string
+
+// envOverridesFromLegacyMap converts the original {"ENV_VAR": "toml.key"} shape used by
+// NewFromFiles/NewFromReaders into an EnvOverrides with one candidate per legacy entry, so
+// both shapes flow through the same merge+coercion code. Several legacy entries naming the
+// same key become several candidates for it, in no defined order.
+func envOverridesFromLegacyMap(legacy map[string]string) EnvOverrides {
+	if legacy == nil {
+		return nil
+	}
+
+	overrides := make(EnvOverrides, len(legacy))
+	for envKey, confKey := range legacy {
+		overrides[confKey] = append(overrides[confKey], envKey)
+	}
+
+	return overrides
+}
+
+// NewFromFilesWithEnv is NewFromFiles, but envOverrides is keyed by TOML config key and
+// supports multiple candidate environment variables per key, e.g.
+// {"database.host": {"APP_DB_HOST", "DB_HOST"}}. See EnvOverrides for the override and
+// coercion semantics.
+func NewFromFilesWithEnv(filenames, searchPaths []string, envOverrides EnvOverrides) (conf *Conf, filesUsed []string, err error) {
+	if len(filenames) == 0 {
+		return nil, nil, errors.Errorf("at least one filename must be provided")
+	}
+
+	readClosers, filesUsed, err := readClosersFromFiles(filenames, searchPaths)
+	if err != nil {
+		err = errors.Wrap(err, "readClosersFromFiles failed")
+		return nil, nil, err
+	}
+
+	defer func() {
+		for _, rc := range readClosers {
+			if rc != nil {
+				rc.Close()
+			}
+		}
+	}()
+
+	if len(readClosers) == 0 || readClosers[0] == nil {
+		err = errors.Errorf("first config file must exist: %s", filenames[0])
+		return nil, nil, err
+	}
+
+	readers := make([]io.Reader, len(readClosers))
+	for i := range readClosers {
+		readers[i] = readClosers[i]
+	}
+
+	conf, err = load(readers, filesUsed, decodersForFilenames(filesUsed), envOverrides)
+	if err != nil {
+		err = errors.Wrap(err, "conf.load failed")
+		return nil, nil, err
+	}
+
+	conf.origFilenames = filenames
+	conf.origSearchPaths = searchPaths
+	conf.origEnvOverrides = envOverrides
+	conf.filesUsed = filesUsed
+
+	return conf, filesUsed, nil
+}
+
+// NewFromReadersWithEnv is NewFromReaders, but envOverrides is keyed by TOML config key
+// and supports multiple candidate environment variables per key. See EnvOverrides for
+// the override and coercion semantics.
+func NewFromReadersWithEnv(readers []io.Reader, envOverrides EnvOverrides) (conf *Conf, err error) {
+	if len(readers) == 0 {
+		return nil, errors.Errorf("at least one reader must be provided")
+	}
+
+	conf, err = load(readers, nil, nil, envOverrides)
+	if err != nil {
+		err = errors.Wrap(err, "conf.load failed")
+		return nil, err
+	}
+
+	return conf, nil
+}
+
+// coerceEnvValue converts raw (a string read from the environment) to the type of
+// existing, so that an env-var override doesn't silently turn a typed setting (int,
+// float, bool, datetime, array) into a string that later typed Conf getters choke on. If
+// existing is nil (the key wasn't already set), raw is returned unchanged as a string.
+func coerceEnvValue(existing interface{}, raw string) (interface{}, error) {
+	switch existing.(type) {
+	case nil:
+		return raw, nil
+
+	case string:
+		return raw, nil
+
+	case bool:
+		coerced, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse %q as bool", raw)
+		}
+		return coerced, nil
+
+	case int64:
+		coerced, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse %q as int", raw)
+		}
+		return coerced, nil
+
+	case float64:
+		coerced, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse %q as float", raw)
+		}
+		return coerced, nil
+
+	case time.Time:
+		coerced, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse %q as an RFC 3339 datetime", raw)
+		}
+		return coerced, nil
+
+	default:
+		// go-toml's TreeFromMap materializes homogeneous arrays as concretely-typed
+		// slices (e.g. []int64, []string), not []interface{}, so array coercion has to
+		// be driven by reflection rather than a single []interface{} case.
+		existingSlice := reflect.ValueOf(existing)
+		if existingSlice.Kind() != reflect.Slice {
+			return raw, nil
+		}
+
+		elemType := existingSlice.Type().Elem()
+		elemSample := reflect.Zero(elemType).Interface()
+		if existingSlice.Len() > 0 {
+			elemSample = existingSlice.Index(0).Interface()
+		}
+
+		parts := strings.Split(raw, envArraySeparator)
+		coerced := reflect.MakeSlice(reflect.SliceOf(elemType), len(parts), len(parts))
+		for i, part := range parts {
+			elem, err := coerceEnvValue(elemSample, strings.TrimSpace(part))
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to parse array element %q", part)
+			}
+			coerced.Index(i).Set(reflect.ValueOf(elem))
+		}
+		return coerced.Interface(), nil
+	}
+}
@@ -0,0 +1,129 @@
+/*
+BSD 3-Clause License
+
+Copyright (c) 2018, Psiphon Inc.
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+* Redistributions of source code must retain the above copyright notice, this
+  list of conditions and the following disclaimer.
+
+* Redistributions in binary form must reproduce the above copyright notice,
+  this list of conditions and the following disclaimer in the documentation
+  and/or other materials provided with the distribution.
+
+* Neither the name of the copyright holder nor the names of its
+  contributors may be used to endorse or promote products derived from
+  this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package multoml
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestNewFromReadersWithEnv(t *testing.T) {
+	type args struct {
+		toml         string
+		envOverrides EnvOverrides
+	}
+	tests := []struct {
+		name              string
+		args              args
+		environmentValues map[string]string
+		wantTomlString    string
+		wantErr           bool
+	}{
+		{
+			name: "Success: first candidate set wins",
+			args: args{
+				toml:         "port = 1\n",
+				envOverrides: EnvOverrides{"port": {"APP_PORT", "PORT"}},
+			},
+			environmentValues: map[string]string{"APP_PORT": "2", "PORT": "3"},
+			wantTomlString:    "port = 2\n",
+		},
+		{
+			name: "Success: falls through to second candidate",
+			args: args{
+				toml:         "port = 1\n",
+				envOverrides: EnvOverrides{"port": {"APP_PORT", "PORT"}},
+			},
+			environmentValues: map[string]string{"PORT": "3"},
+			wantTomlString:    "port = 3\n",
+		},
+		{
+			name: "Success: coerces to existing bool type",
+			args: args{
+				toml:         "enabled = false\n",
+				envOverrides: EnvOverrides{"enabled": {"ENABLED"}},
+			},
+			environmentValues: map[string]string{"ENABLED": "true"},
+			wantTomlString:    "enabled = true\n",
+		},
+		{
+			name: "Success: coerces to existing array type",
+			args: args{
+				toml:         "hosts = [1, 2]\n",
+				envOverrides: EnvOverrides{"hosts": {"HOSTS"}},
+			},
+			environmentValues: map[string]string{"HOSTS": "3, 4, 5"},
+			wantTomlString:    "hosts = [3, 4, 5]\n",
+		},
+		{
+			name: "Error: value doesn't coerce to existing type",
+			args: args{
+				toml:         "port = 1\n",
+				envOverrides: EnvOverrides{"port": {"PORT"}},
+			},
+			environmentValues: map[string]string{"PORT": "not-a-number"},
+			wantErr:           true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.environmentValues {
+				os.Setenv(k, v)
+				defer os.Unsetenv(k)
+			}
+
+			readers := []io.Reader{strings.NewReader(tt.args.toml)}
+
+			gotConf, err := NewFromReadersWithEnv(readers, tt.args.envOverrides)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewFromReadersWithEnv() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+
+			if err != nil {
+				return
+			}
+
+			gotTomlString, err := gotConf.ToTomlString()
+			if err != nil {
+				t.Fatalf("gotConf.ToTomlString failed: %v", err)
+			}
+			if gotTomlString != tt.wantTomlString {
+				t.Errorf("gotConf = {%v}, want {%v}", gotTomlString, tt.wantTomlString)
+			}
+		})
+	}
+}
@@ -0,0 +1,234 @@
+/*
+ * BSD 3-Clause License
+ * Copyright (c) 2018, Psiphon Inc.
+ * All rights reserved.
+ */
+
+package multoml
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	toml "github.com/pelletier/go-toml"
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Format names a config file format that a Decoder can handle, for use where a file
+// extension isn't available to infer it from (e.g. NewFromReadersWithFormats).
+type Format string
+
+// The formats multoml has built-in Decoders for.
+const (
+	FormatTOML   Format = "toml"
+	FormatJSON   Format = "json"
+	FormatYAML   Format = "yaml"
+	FormatDotenv Format = "dotenv"
+)
+
+// Decoder turns raw config data into a generic tree suitable for merging. Merging always
+// happens at this map[string]interface{} level (see mergeMaps); a Decoder just needs to
+// produce the same shape toml.Tree.ToMap() would: nested maps, slices, and TOML-ish
+// scalar types (string, bool, int64, float64, time.Time).
+type Decoder interface {
+	Decode(r io.Reader) (map[string]interface{}, error)
+}
+
+// DecoderFunc adapts a plain function to a Decoder.
+type DecoderFunc func(r io.Reader) (map[string]interface{}, error)
+
+// Decode calls f.
+func (f DecoderFunc) Decode(r io.Reader) (map[string]interface{}, error) {
+	return f(r)
+}
+
+var (
+	tomlDecoderInstance   Decoder = DecoderFunc(decodeTOML)
+	jsonDecoderInstance   Decoder = DecoderFunc(decodeJSON)
+	yamlDecoderInstance   Decoder = DecoderFunc(decodeYAML)
+	dotenvDecoderInstance Decoder = DecoderFunc(decodeDotenv)
+)
+
+// decodersForFilenames returns the Decoder to use for each of names, selected by file
+// extension (see decoderForFilename). It's used by NewFromFiles/NewFromFilesWithEnv,
+// where the source filename is known.
+func decodersForFilenames(names []string) []Decoder {
+	decoders := make([]Decoder, len(names))
+	for i, name := range names {
+		decoders[i] = decoderForFilename(name)
+	}
+	return decoders
+}
+
+// decoderForFilename selects a Decoder by name's extension, defaulting to TOML (the
+// package's original, and still primary, format) for anything unrecognized.
+func decoderForFilename(name string) Decoder {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".json":
+		return jsonDecoderInstance
+	case ".yaml", ".yml":
+		return yamlDecoderInstance
+	case ".env":
+		return dotenvDecoderInstance
+	default:
+		return tomlDecoderInstance
+	}
+}
+
+// decoderForFormat selects a Decoder by explicit Format hint, for callers (such as
+// NewFromReadersWithFormats) that have no filename to infer one from.
+func decoderForFormat(f Format) (Decoder, error) {
+	switch f {
+	case "", FormatTOML:
+		return tomlDecoderInstance, nil
+	case FormatJSON:
+		return jsonDecoderInstance, nil
+	case FormatYAML:
+		return yamlDecoderInstance, nil
+	case FormatDotenv:
+		return dotenvDecoderInstance, nil
+	default:
+		return nil, errors.Errorf("unknown format: %s", f)
+	}
+}
+
+// NewFromReadersWithFormats is NewFromReaders, but each reader's format is given
+// explicitly via formats (same length as readers) instead of being assumed to be TOML.
+func NewFromReadersWithFormats(readers []io.Reader, formats []Format, envOverrides map[string]string) (conf *Conf, err error) {
+	if len(readers) == 0 {
+		return nil, errors.Errorf("at least one reader must be provided")
+	}
+	if len(formats) != len(readers) {
+		return nil, errors.Errorf("formats must have the same length as readers")
+	}
+
+	decoders := make([]Decoder, len(formats))
+	for i, f := range formats {
+		decoders[i], err = decoderForFormat(f)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid format for reader#%d", i)
+		}
+	}
+
+	conf, err = load(readers, nil, decoders, envOverridesFromLegacyMap(envOverrides))
+	if err != nil {
+		err = errors.Wrap(err, "conf.load failed")
+		return nil, err
+	}
+
+	return conf, nil
+}
+
+func decodeTOML(r io.Reader) (map[string]interface{}, error) {
+	tree, err := toml.LoadReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return tree.ToMap(), nil
+}
+
+func decodeJSON(r io.Reader) (map[string]interface{}, error) {
+	m := map[string]interface{}{}
+	if err := json.NewDecoder(r).Decode(&m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func decodeYAML(r io.Reader) (map[string]interface{}, error) {
+	raw := map[interface{}]interface{}{}
+	if err := yaml.NewDecoder(r).Decode(&raw); err != nil {
+		return nil, err
+	}
+	return normalizeYAMLMap(raw), nil
+}
+
+// normalizeYAMLMap converts the map[interface{}]interface{} that yaml.v2 produces for
+// nested mappings into the map[string]interface{} shape the rest of the merge pipeline
+// (and toml.TreeFromMap) expects.
+func normalizeYAMLMap(in map[interface{}]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(in))
+	for k, v := range in {
+		out[fmt.Sprintf("%v", k)] = normalizeYAMLValue(v)
+	}
+	return out
+}
+
+func normalizeYAMLValue(v interface{}) interface{} {
+	switch vv := v.(type) {
+	case map[interface{}]interface{}:
+		return normalizeYAMLMap(vv)
+	case []interface{}:
+		out := make([]interface{}, len(vv))
+		for i, e := range vv {
+			out[i] = normalizeYAMLValue(e)
+		}
+		return out
+	default:
+		return vv
+	}
+}
+
+// decodeDotenv decodes KEY=value lines (blank lines and lines starting with '#' are
+// skipped) into a tree. KEY may be a dotted path (e.g. "database.host=localhost") to
+// target a nested TOML key; a value may be wrapped in matching single or double quotes,
+// which are stripped.
+func decodeDotenv(r io.Reader) (map[string]interface{}, error) {
+	m := map[string]interface{}{}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			return nil, errors.Errorf("invalid dotenv line: %q", line)
+		}
+
+		key := strings.TrimSpace(parts[0])
+		val := unquoteDotenvValue(strings.TrimSpace(parts[1]))
+
+		setDottedKey(m, key, val)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+func unquoteDotenvValue(v string) string {
+	if len(v) >= 2 {
+		quote := v[0]
+		if (quote == '"' || quote == '\'') && v[len(v)-1] == quote {
+			return v[1 : len(v)-1]
+		}
+	}
+	return v
+}
+
+// setDottedKey sets dottedKey (e.g. "database.host") to val within m, creating
+// intermediate nested maps as needed.
+func setDottedKey(m map[string]interface{}, dottedKey string, val interface{}) {
+	parts := strings.Split(dottedKey, ".")
+
+	cur := m
+	for _, p := range parts[:len(parts)-1] {
+		next, ok := cur[p].(map[string]interface{})
+		if !ok {
+			next = map[string]interface{}{}
+			cur[p] = next
+		}
+		cur = next
+	}
+
+	cur[parts[len(parts)-1]] = val
+}
@@ -0,0 +1,115 @@
+/*
+BSD 3-Clause License
+
+Copyright (c) 2018, Psiphon Inc.
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+* Redistributions of source code must retain the above copyright notice, this
+  list of conditions and the following disclaimer.
+
+* Redistributions in binary form must reproduce the above copyright notice,
+  this list of conditions and the following disclaimer in the documentation
+  and/or other materials provided with the distribution.
+
+* Neither the name of the copyright holder nor the names of its
+  contributors may be used to endorse or promote products derived from
+  this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package multoml
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestNewFromReadersWithFormats(t *testing.T) {
+	type args struct {
+		contents []string
+		formats  []Format
+	}
+	tests := []struct {
+		name           string
+		args           args
+		wantTomlString string
+		wantErr        bool
+	}{
+		{
+			name: "Success: JSON primary",
+			args: args{
+				contents: []string{`{"database": {"host": "localhost", "port": 5432}}`},
+				formats:  []Format{FormatJSON},
+			},
+			wantTomlString: "\n[database]\n  host = \"localhost\"\n  port = 5432.0\n",
+		},
+		{
+			name: "Success: TOML override merged onto YAML primary",
+			args: args{
+				contents: []string{
+					"database:\n  host: localhost\n  port: 5432\n",
+					"port = 1\n",
+				},
+				formats: []Format{FormatYAML, FormatTOML},
+			},
+			wantTomlString: "port = 1\n\n[database]\n  host = \"localhost\"\n  port = 5432\n",
+		},
+		{
+			name: "Success: dotenv dotted keys",
+			args: args{
+				contents: []string{"database.host=localhost\n# a comment\n\ndatabase.port=5432\n"},
+				formats:  []Format{FormatDotenv},
+			},
+			wantTomlString: "\n[database]\n  host = \"localhost\"\n  port = \"5432\"\n",
+		},
+		{
+			name: "Error: mismatched formats length",
+			args: args{
+				contents: []string{"a = 1\n"},
+				formats:  nil,
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			readers := make([]io.Reader, len(tt.args.contents))
+			for i, c := range tt.args.contents {
+				readers[i] = strings.NewReader(c)
+			}
+
+			gotConf, err := NewFromReadersWithFormats(readers, tt.args.formats, nil)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewFromReadersWithFormats() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+
+			if err != nil {
+				return
+			}
+
+			gotTomlString, err := gotConf.ToTomlString()
+			if err != nil {
+				t.Fatalf("gotConf.ToTomlString failed: %v", err)
+			}
+			if gotTomlString != tt.wantTomlString {
+				t.Errorf("gotConf = {%v}, want {%v}", gotTomlString, tt.wantTomlString)
+			}
+		})
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"sync"
 
 	"github.com/imdario/mergo"
 	toml "github.com/pelletier/go-toml"
@@ -19,8 +20,82 @@ import (
 
 // Conf holds the loaded config. It can be accessed with toml.Tree methods.
 // See: https://godoc.org/github.com/pelletier/go-toml
+//
+// Get, Has, Set, Keys, ToMap, and ToTomlString are shadowed below with lock-guarded
+// versions that are safe to call concurrently with a Watch-triggered reload. Any other
+// toml.Tree method reached through the embedding (GetPath, Delete, SetPath, ...) is not
+// guarded and must not be called concurrently with Watch.
 type Conf struct {
 	toml.Tree
+
+	// mu guards Tree and filesUsed against concurrent access from a Watch-triggered
+	// reload. It must be held (for read, via the wrapper methods below) by anything
+	// reading Tree or filesUsed, and (for write, for the whole read-diff-install
+	// sequence, not just the final assignment) by reload when it swaps them in. Tree
+	// embeds its own map, so a reload that only locked the final assignment would let a
+	// concurrent Set mutate that same map while reload's diff was still reading it.
+	mu sync.RWMutex
+
+	// The following fields are only populated when the Conf was built by NewFromFiles,
+	// and exist to support Watch/OnChange re-running the same merge pipeline later.
+	origFilenames    []string
+	origSearchPaths  []string
+	origEnvOverrides EnvOverrides
+	filesUsed        []string
+
+	watchOnce sync.Once
+	watch     *watchState
+}
+
+// Get returns the value at key, or nil if it isn't set. It shadows the embedded
+// toml.Tree's Get with a version that's safe to call concurrently with a Watch-triggered
+// reload.
+func (c *Conf) Get(key string) interface{} {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.Tree.Get(key)
+}
+
+// Has reports whether key is set. It shadows the embedded toml.Tree's Has with a version
+// that's safe to call concurrently with a Watch-triggered reload.
+func (c *Conf) Has(key string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.Tree.Has(key)
+}
+
+// ToTomlString renders the config as TOML text. It shadows the embedded toml.Tree's
+// ToTomlString with a version that's safe to call concurrently with a Watch-triggered
+// reload.
+func (c *Conf) ToTomlString() (string, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.Tree.ToTomlString()
+}
+
+// ToMap returns the config as a map[string]interface{}. It shadows the embedded
+// toml.Tree's ToMap with a version that's safe to call concurrently with a
+// Watch-triggered reload.
+func (c *Conf) ToMap() map[string]interface{} {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.Tree.ToMap()
+}
+
+// Keys returns the top-level keys of the config. It shadows the embedded toml.Tree's
+// Keys with a version that's safe to call concurrently with a Watch-triggered reload.
+func (c *Conf) Keys() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.Tree.Keys()
+}
+
+// Set sets key to value. It shadows the embedded toml.Tree's Set with a version that's
+// safe to call concurrently with a Watch-triggered reload.
+func (c *Conf) Set(key string, value interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Tree.Set(key, value)
 }
 
 // NewFromFiles loads the config from filenames.
@@ -34,6 +109,9 @@ type Conf struct {
 // used directly.)
 // envOverrides has the format {"DATABASE_HOST": "database.host"} where "DATABASE_HOST" is
 // the envrionment variable name and "database.host" is the TOML config key to override.
+// Values are coerced to the type already at the key, as described for EnvOverrides. To
+// override a key from more than one candidate environment variable, use
+// NewFromFilesWithEnv instead.
 func NewFromFiles(filenames, searchPaths []string, envOverrides map[string]string) (conf *Conf, filesUsed []string, err error) {
 	if len(filenames) == 0 {
 		return nil, nil, errors.Errorf("at least one filename must be provided")
@@ -63,12 +141,19 @@ func NewFromFiles(filenames, searchPaths []string, envOverrides map[string]strin
 		readers[i] = readClosers[i]
 	}
 
-	conf, err = load(readers, filesUsed, envOverrides)
+	expandedEnvOverrides := envOverridesFromLegacyMap(envOverrides)
+
+	conf, err = load(readers, filesUsed, decodersForFilenames(filesUsed), expandedEnvOverrides)
 	if err != nil {
 		err = errors.Wrap(err, "conf.load failed")
 		return nil, nil, err
 	}
 
+	conf.origFilenames = filenames
+	conf.origSearchPaths = searchPaths
+	conf.origEnvOverrides = expandedEnvOverrides
+	conf.filesUsed = filesUsed
+
 	return conf, filesUsed, nil
 }
 
@@ -78,12 +163,15 @@ func NewFromFiles(filenames, searchPaths []string, envOverrides map[string]strin
 // override that.
 // envOverrides has the format {"DATABASE_HOST": "database.host"} where "DATABASE_HOST" is
 // the envrionment variable name and "database.host" is the TOML config key to override.
+// Values are coerced to the type already at the key, as described for EnvOverrides. To
+// override a key from more than one candidate environment variable, use
+// NewFromReadersWithEnv instead.
 func NewFromReaders(readers []io.Reader, envOverrides map[string]string) (conf *Conf, err error) {
 	if len(readers) == 0 {
 		return nil, errors.Errorf("at least one reader must be provided")
 	}
 
-	conf, err = load(readers, nil, envOverrides)
+	conf, err = load(readers, nil, nil, envOverridesFromLegacyMap(envOverrides))
 	if err != nil {
 		err = errors.Wrap(err, "conf.load failed")
 		return nil, err
@@ -92,37 +180,49 @@ func NewFromReaders(readers []io.Reader, envOverrides map[string]string) (conf *
 	return conf, nil
 }
 
-func load(readers []io.Reader, readerNames []string, envOverrides map[string]string) (*Conf, error) {
-	var confTOML *toml.Tree
+// load decodes and merges readers into a single Conf. decoders, if non-nil, gives the
+// Decoder to use for the reader at the same index; a nil entry (or a decoders slice
+// shorter than readers) falls back to the TOML decoder, preserving the package's
+// original TOML-only behaviour.
+func load(readers []io.Reader, readerNames []string, decoders []Decoder, envOverrides EnvOverrides) (*Conf, error) {
+	var merged map[string]interface{}
 
 	for i, r := range readers {
 		if r == nil {
 			continue
 		}
 
-		newTOML, err := toml.LoadReader(r)
+		decoder := tomlDecoderInstance
+		if len(decoders) > i && decoders[i] != nil {
+			decoder = decoders[i]
+		}
+
+		decoded, err := decoder.Decode(r)
 		if err != nil {
 			readerName := fmt.Sprintf("reader#%d", i)
-			if len(readerNames) > i {
+			if len(readerNames) > i && readerNames[i] != "" {
 				readerName = readerNames[i]
 			}
-			errors.Wrapf(err, "failed to load TOML: %s", readerName)
-			return nil, err
+			return nil, errors.Wrapf(err, "failed to decode: %s", readerName)
 		}
 
-		confTOML, err = mergeConfig(confTOML, newTOML)
+		merged, err = mergeMaps(merged, decoded)
 		if err != nil {
-			err = errors.Wrap(err, "mergeConfig failed")
+			err = errors.Wrap(err, "mergeMaps failed")
 			return nil, err
 		}
 	}
 
-	if confTOML == nil {
+	if merged == nil {
 		return nil, errors.Errorf("load resulted in nil config")
 	}
 
+	confTOML, err := toml.TreeFromMap(merged)
+	if err != nil {
+		return nil, errors.Wrap(err, "toml.TreeFromMap failed")
+	}
+
 	// Read and merge environment variable override
-	var err error
 	confTOML, err = mergeEnvironment(confTOML, envOverrides)
 	if err != nil {
 		err = errors.Wrap(err, "mergeEnvironment failed")
@@ -192,9 +292,28 @@ func mergeConfig(base, override *toml.Tree) (*toml.Tree, error) {
 	return res, nil
 }
 
-// mergeEnvironment takes a config and looks for any of a environment variable keys in fromEnv to
-// set or override missing or existing configuration values and returns the result.
-func mergeEnvironment(config *toml.Tree, envOverrides map[string]string) (*toml.Tree, error) {
+// mergeMaps merges override on top of base, in place, and returns the result. It's the
+// map-level equivalent of mergeConfig, used by load so that pluggable Decoders never need
+// to round-trip through a toml.Tree until the very end of the merge pipeline.
+func mergeMaps(base, override map[string]interface{}) (map[string]interface{}, error) {
+	if base == nil {
+		return override, nil
+	}
+	if override == nil {
+		return base, nil
+	}
+
+	if err := mergo.Merge(&override, base); err != nil {
+		return nil, errors.Wrap(err, "mergo.Merge failed")
+	}
+
+	return override, nil
+}
+
+// mergeEnvironment takes a config and, for each confKey in envOverrides, looks for the
+// first set of its candidate environment variables to set or override that configuration
+// value, coercing it to the type already at confKey (if any). It returns the result.
+func mergeEnvironment(config *toml.Tree, envOverrides EnvOverrides) (*toml.Tree, error) {
 	if config == nil {
 		return nil, errors.Errorf("config is required")
 	}
@@ -204,10 +323,22 @@ func mergeEnvironment(config *toml.Tree, envOverrides map[string]string) (*toml.
 		return nil, errors.Wrap(err, "failed to create TOML tree")
 	}
 
-	for envKey, confKey := range envOverrides {
-		val, ok := os.LookupEnv(envKey)
-		if ok {
-			envConfig.Set(confKey, val)
+	// Keys are applied in map iteration order, but that's fine: each confKey is
+	// independent, and within a confKey the candidates are tried in written order.
+	for confKey, envKeys := range envOverrides {
+		for _, envKey := range envKeys {
+			val, ok := os.LookupEnv(envKey)
+			if !ok {
+				continue
+			}
+
+			coerced, err := coerceEnvValue(config.Get(confKey), val)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to coerce %s from %s", confKey, envKey)
+			}
+
+			envConfig.Set(confKey, coerced)
+			break
 		}
 	}
 
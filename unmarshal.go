@@ -0,0 +1,109 @@
+/*
+ * BSD 3-Clause License
+ * Copyright (c) 2018, Psiphon Inc.
+ * All rights reserved.
+ */
+
+package multoml
+
+import (
+	"strings"
+
+	toml "github.com/pelletier/go-toml"
+	"github.com/pkg/errors"
+)
+
+// UnmarshalOption configures Conf.Unmarshal. See Strict, RequireKeys, and Validate.
+type UnmarshalOption func(*unmarshalOptions)
+
+type unmarshalOptions struct {
+	strict      bool
+	requireKeys []string
+	validateFns []func(*Conf) error
+}
+
+// Strict causes Unmarshal to fail if the config contains any key that doesn't
+// correspond to a field of dst, instead of silently ignoring it. This catches typos in
+// config files (e.g. "datbase.host") that would otherwise go unnoticed.
+func Strict() UnmarshalOption {
+	return func(o *unmarshalOptions) {
+		o.strict = true
+	}
+}
+
+// RequireKeys causes Unmarshal to fail if any of keys (dotted paths, e.g.
+// "database.host") is missing from the config, after loading, merging, and any
+// environment variable overrides have been applied.
+func RequireKeys(keys []string) UnmarshalOption {
+	return func(o *unmarshalOptions) {
+		o.requireKeys = append(o.requireKeys, keys...)
+	}
+}
+
+// Validate registers fn to run against c after a successful decode, before Unmarshal
+// returns. Multiple Validate options run in the order given; the first error returned by
+// any of them is returned by Unmarshal, and later ones are skipped.
+func Validate(fn func(*Conf) error) UnmarshalOption {
+	return func(o *unmarshalOptions) {
+		o.validateFns = append(o.validateFns, fn)
+	}
+}
+
+// Unmarshal decodes c's merged config into dst, which should be a pointer to a struct
+// (see the pelletier/go-toml struct tag conventions that toml.Tree.Unmarshal follows).
+// opts can request strict unknown-key checking, required keys, and/or custom validation
+// -- see Strict, RequireKeys, and Validate.
+func (c *Conf) Unmarshal(dst interface{}, opts ...UnmarshalOption) error {
+	var o unmarshalOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	// The required-keys check and the serialization below must see the same snapshot of
+	// c, even if a Watch-triggered reload runs concurrently; otherwise a reload landing
+	// between the two could let a since-removed required key slip through, or fail the
+	// check against a key a concurrent reload is about to add. Taking c.mu once for both
+	// closes that window.
+	tomlString, err := c.requireKeysAndSerialize(o.requireKeys)
+	if err != nil {
+		return err
+	}
+
+	decoder := toml.NewDecoder(strings.NewReader(tomlString))
+	if o.strict {
+		decoder = decoder.Strict(true)
+	}
+
+	if err := decoder.Decode(dst); err != nil {
+		return errors.Wrap(err, "failed to decode config")
+	}
+
+	for _, fn := range o.validateFns {
+		if err := fn(c); err != nil {
+			return errors.Wrap(err, "validation failed")
+		}
+	}
+
+	return nil
+}
+
+// requireKeysAndSerialize checks that every key in requireKeys is present in c, and
+// renders c as TOML text for the Decoder, as a single operation under c.mu so the two
+// steps see a consistent snapshot despite a concurrent Watch-triggered reload.
+func (c *Conf) requireKeysAndSerialize(requireKeys []string) (string, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for _, key := range requireKeys {
+		if !c.Tree.Has(key) {
+			return "", errors.Errorf("required key missing: %s", key)
+		}
+	}
+
+	tomlString, err := c.Tree.ToTomlString()
+	if err != nil {
+		return "", errors.Wrap(err, "failed to serialize config for unmarshal")
+	}
+
+	return tomlString, nil
+}
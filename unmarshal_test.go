@@ -0,0 +1,101 @@
+/*
+BSD 3-Clause License
+
+Copyright (c) 2018, Psiphon Inc.
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+* Redistributions of source code must retain the above copyright notice, this
+  list of conditions and the following disclaimer.
+
+* Redistributions in binary form must reproduce the above copyright notice,
+  this list of conditions and the following disclaimer in the documentation
+  and/or other materials provided with the distribution.
+
+* Neither the name of the copyright holder nor the names of its
+  contributors may be used to endorse or promote products derived from
+  this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package multoml
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+type unmarshalTestConfig struct {
+	Database struct {
+		Host string `toml:"host"`
+		Port int    `toml:"port"`
+	} `toml:"database"`
+}
+
+func TestConfUnmarshal(t *testing.T) {
+	tests := []struct {
+		name    string
+		toml    string
+		opts    []UnmarshalOption
+		wantErr bool
+	}{
+		{
+			name: "Success: no options",
+			toml: "[database]\nhost = \"localhost\"\nport = 5432\n",
+		},
+		{
+			name:    "Error: strict rejects unknown key",
+			toml:    "[database]\nhost = \"localhost\"\nport = 5432\nunknown = true\n",
+			opts:    []UnmarshalOption{Strict()},
+			wantErr: true,
+		},
+		{
+			name: "Success: required key present",
+			toml: "[database]\nhost = \"localhost\"\nport = 5432\n",
+			opts: []UnmarshalOption{RequireKeys([]string{"database.host"})},
+		},
+		{
+			name:    "Error: required key missing",
+			toml:    "[database]\nport = 5432\n",
+			opts:    []UnmarshalOption{RequireKeys([]string{"database.host"})},
+			wantErr: true,
+		},
+		{
+			name: "Error: validate hook fails",
+			toml: "[database]\nhost = \"localhost\"\nport = 5432\n",
+			opts: []UnmarshalOption{Validate(func(c *Conf) error {
+				return errors.New("always fails")
+			})},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf, err := NewFromReaders([]io.Reader{strings.NewReader(tt.toml)}, nil)
+			if err != nil {
+				t.Fatalf("NewFromReaders failed: %v", err)
+			}
+
+			var dst unmarshalTestConfig
+			err = conf.Unmarshal(&dst, tt.opts...)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Unmarshal() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
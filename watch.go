@@ -0,0 +1,295 @@
+/*
+ * BSD 3-Clause License
+ * Copyright (c) 2018, Psiphon Inc.
+ * All rights reserved.
+ */
+
+package multoml
+
+import (
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	toml "github.com/pelletier/go-toml"
+	"github.com/pkg/errors"
+)
+
+// watchDebounce is how long Watch waits, after the most recent detected change to a
+// watched file, before re-running the merge pipeline; each new event restarts the wait.
+// Editors and atomic-rename saves commonly
+// produce several filesystem events in quick succession for what is conceptually a
+// single change, so without debouncing a single save could trigger multiple reloads.
+const watchDebounce = 250 * time.Millisecond
+
+// ChangeFunc is the callback signature for Conf.OnChange. old is the config as it was
+// before the reload and new is the freshly loaded and merged config (new is also the
+// same *Conf that Watch was called on -- it's updated in place). changed is the sorted
+// list of dotted key paths that were added, removed, or had their value changed.
+type ChangeFunc func(old, new *Conf, changed []string)
+
+// watchState holds the fsnotify plumbing for a watched Conf. It's kept separate from
+// Conf's other fields so that a Conf which is never Watch()ed pays no cost beyond a nil
+// pointer.
+type watchState struct {
+	mu          sync.Mutex
+	subscribers []ChangeFunc
+	watcher     *fsnotify.Watcher
+	stopCh      chan struct{}
+}
+
+// OnChange registers fn to be called after every reload triggered by Watch that succeeds
+// and changes at least one key; a reload that leaves every key as it was notifies no one.
+// fn receives the config as it was, the newly reloaded config, and the dotted key paths
+// that changed between them. OnChange may be called before or after Watch is started;
+// callbacks are simply queued until the first reload occurs.
+func (c *Conf) OnChange(fn ChangeFunc) {
+	c.ensureWatchState()
+
+	c.watch.mu.Lock()
+	defer c.watch.mu.Unlock()
+	c.watch.subscribers = append(c.watch.subscribers, fn)
+}
+
+// Watch begins monitoring, via fsnotify, the files that were used to build c (i.e., the
+// filesUsed returned alongside c by NewFromFiles). Whenever one of them changes, Watch
+// debounces rapid successive writes, re-runs the same merge pipeline that produced c,
+// and -- if that succeeds and any key changed -- swaps c's content in place and notifies
+// the callbacks registered with OnChange. If the reload fails (for example, the edited file contains
+// invalid TOML), c is left unchanged and the failed reload is silently ignored; a bad
+// edit shouldn't take a running service down.
+//
+// Watch requires c to have been built by NewFromFiles; Confs built by NewFromReaders
+// have no backing files to watch. The returned stop function stops the watch and
+// releases the underlying fsnotify watcher; it is safe to call more than once.
+func (c *Conf) Watch() (stop func(), err error) {
+	c.mu.RLock()
+	filesUsed := append([]string(nil), c.filesUsed...)
+	c.mu.RUnlock()
+
+	if len(filesUsed) == 0 {
+		return nil, errors.Errorf("Watch requires a Conf built by NewFromFiles")
+	}
+
+	c.ensureWatchState()
+
+	c.watch.mu.Lock()
+	defer c.watch.mu.Unlock()
+
+	if c.watch.watcher != nil {
+		// Already watching.
+		return c.stopWatch, nil
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, errors.Wrap(err, "fsnotify.NewWatcher failed")
+	}
+
+	for _, f := range filesUsed {
+		if f == "" {
+			// This candidate file didn't exist when c was loaded, so there's nothing
+			// to watch yet. (If it's created later, neither its creation nor its
+			// edits trigger a reload; its content is only merged in by a reload that
+			// a change to one of the watched files triggers.)
+			continue
+		}
+		if err := watcher.Add(f); err != nil {
+			watcher.Close()
+			return nil, errors.Wrapf(err, "failed to watch %s", f)
+		}
+	}
+
+	c.watch.watcher = watcher
+	c.watch.stopCh = make(chan struct{})
+
+	go c.watchLoop(watcher, c.watch.stopCh)
+
+	return c.stopWatch, nil
+}
+
+// stopWatch stops and releases c's fsnotify watcher, if any is running.
+func (c *Conf) stopWatch() {
+	c.watch.mu.Lock()
+	defer c.watch.mu.Unlock()
+
+	if c.watch.watcher == nil {
+		return
+	}
+
+	close(c.watch.stopCh)
+	c.watch.watcher.Close()
+	c.watch.watcher = nil
+}
+
+func (c *Conf) watchLoop(watcher *fsnotify.Watcher, stopCh chan struct{}) {
+	var debounce *time.Timer
+	pending := false
+
+	for {
+		select {
+		case <-stopCh:
+			return
+
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+
+			if event.Op&(fsnotify.Rename|fsnotify.Remove) != 0 {
+				// A common save pattern is to write a temp file and rename it over
+				// the original, which drops fsnotify's watch on the original inode.
+				// Re-add the watch on the new file so subsequent writes are seen.
+				go reAddWatch(watcher, event.Name)
+			}
+
+			pending = true
+			debounce = resetTimer(debounce, watchDebounce)
+
+		case <-timerC(debounce):
+			// The timer has fired and its channel is now drained; resetTimer must not
+			// try to Stop/drain it again on the next event, so forget it entirely and
+			// let resetTimer start a fresh one.
+			debounce = nil
+			if pending {
+				pending = false
+				c.reload()
+			}
+
+		case _, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+		}
+	}
+}
+
+// reAddWatch retries adding name to watcher for a short while, since the replacement
+// file from an atomic-rename save may not exist for the first instant after the event
+// fires.
+func reAddWatch(watcher *fsnotify.Watcher, name string) {
+	for i := 0; i < 10; i++ {
+		if err := watcher.Add(name); err == nil {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// resetTimer returns a timer that will fire after d, reusing t if it's non-nil.
+func resetTimer(t *time.Timer, d time.Duration) *time.Timer {
+	if t == nil {
+		return time.NewTimer(d)
+	}
+	if !t.Stop() {
+		<-t.C
+	}
+	t.Reset(d)
+	return t
+}
+
+// timerC returns t's channel, or a nil channel (which blocks forever in a select) if t
+// is nil.
+func timerC(t *time.Timer) <-chan time.Time {
+	if t == nil {
+		return nil
+	}
+	return t.C
+}
+
+// reload re-runs the merge pipeline that originally built c. On success, if any key
+// changed, it swaps c's content in place, under c.mu, and notifies subscribers with the
+// diff; on failure, or when nothing changed, it leaves c unchanged. The read, diff, and swap all happen under a single c.mu.Lock():
+// toml.Tree embeds its own map, so oldTree below shares it with c.Tree until the swap
+// reassigns c.Tree to newConf's (different) map -- releasing the lock between the read
+// and the swap would let a concurrent Set mutate that shared map while diffKeys was
+// still reading it.
+func (c *Conf) reload() {
+	newConf, _, err := NewFromFilesWithEnv(c.origFilenames, c.origSearchPaths, c.origEnvOverrides)
+	if err != nil {
+		return
+	}
+
+	c.mu.Lock()
+	oldTree := c.Tree
+	changed := diffKeys(&oldTree, &newConf.Tree)
+	if len(changed) == 0 {
+		c.mu.Unlock()
+		return
+	}
+	c.Tree = newConf.Tree
+	c.filesUsed = newConf.filesUsed
+	c.mu.Unlock()
+
+	old := &Conf{Tree: oldTree}
+
+	c.watch.mu.Lock()
+	subscribers := append([]ChangeFunc(nil), c.watch.subscribers...)
+	c.watch.mu.Unlock()
+
+	for _, fn := range subscribers {
+		fn(old, c, changed)
+	}
+}
+
+func (c *Conf) ensureWatchState() {
+	c.watchOnce.Do(func() {
+		c.watch = &watchState{}
+	})
+}
+
+// diffKeys returns the sorted list of dotted key paths that were added, removed, or had
+// their value changed between old and new.
+func diffKeys(old, new *toml.Tree) []string {
+	changed := map[string]bool{}
+	diffMaps("", old.ToMap(), new.ToMap(), changed)
+
+	keys := make([]string, 0, len(changed))
+	for k := range changed {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func diffMaps(prefix string, oldMap, newMap map[string]interface{}, changed map[string]bool) {
+	for k, oldVal := range oldMap {
+		path := joinKeyPath(prefix, k)
+		newVal, ok := newMap[k]
+		if !ok {
+			changed[path] = true
+			continue
+		}
+		diffValue(path, oldVal, newVal, changed)
+	}
+
+	for k := range newMap {
+		if _, ok := oldMap[k]; !ok {
+			changed[joinKeyPath(prefix, k)] = true
+		}
+	}
+}
+
+func diffValue(path string, oldVal, newVal interface{}, changed map[string]bool) {
+	oldSub, oldIsMap := oldVal.(map[string]interface{})
+	newSub, newIsMap := newVal.(map[string]interface{})
+
+	if oldIsMap && newIsMap {
+		diffMaps(path, oldSub, newSub, changed)
+		return
+	}
+
+	if !reflect.DeepEqual(oldVal, newVal) {
+		changed[path] = true
+	}
+}
+
+func joinKeyPath(prefix, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return prefix + "." + key
+}
@@ -0,0 +1,283 @@
+/*
+BSD 3-Clause License
+
+Copyright (c) 2018, Psiphon Inc.
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+* Redistributions of source code must retain the above copyright notice, this
+  list of conditions and the following disclaimer.
+
+* Redistributions in binary form must reproduce the above copyright notice,
+  this list of conditions and the following disclaimer in the documentation
+  and/or other materials provided with the distribution.
+
+* Neither the name of the copyright holder nor the names of its
+  contributors may be used to endorse or promote products derived from
+  this software without specific prior written permission.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+
+package multoml
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	toml "github.com/pelletier/go-toml"
+)
+
+func TestDiffKeys(t *testing.T) {
+	oldTree, err := toml.Load("a = 1\nb = 2\n[nested]\nx = 1\n")
+	if err != nil {
+		t.Fatalf("toml.Load failed: %v", err)
+	}
+	newTree, err := toml.Load("a = 1\nb = 3\nc = 4\n[nested]\ny = 2\n")
+	if err != nil {
+		t.Fatalf("toml.Load failed: %v", err)
+	}
+
+	got := diffKeys(oldTree, newTree)
+	want := []string{"b", "c", "nested.x", "nested.y"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("diffKeys() = %v, want %v", got, want)
+	}
+}
+
+func TestWatchDebounceCoalescing(t *testing.T) {
+	dir, fname := writeWatchedFile(t, "value = 1\n")
+
+	conf, _, err := NewFromFiles([]string{filepath.Base(fname)}, []string{dir}, nil)
+	if err != nil {
+		t.Fatalf("NewFromFiles failed: %v", err)
+	}
+
+	var notifications int32
+	changed := make(chan interface{}, 10)
+	conf.OnChange(func(old, new *Conf, changedKeys []string) {
+		atomic.AddInt32(&notifications, 1)
+		changed <- new.Get("value")
+	})
+
+	stop, err := conf.Watch()
+	if err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+	defer stop()
+
+	// Several rapid writes, each well within the debounce window of the one before,
+	// should coalesce into a single reload reflecting only the final write.
+	for i := int64(2); i <= 5; i++ {
+		if err := ioutil.WriteFile(fname, []byte(fmt.Sprintf("value = %d\n", i)), 0644); err != nil {
+			t.Fatalf("ioutil.WriteFile failed: %v", err)
+		}
+		time.Sleep(watchDebounce / 4)
+	}
+
+	got := waitForChange(t, changed, 3*time.Second)
+	if got != int64(5) {
+		t.Errorf("reloaded value = %v, want 5", got)
+	}
+
+	// Give any further (undesired) notifications time to arrive before we check the
+	// count.
+	time.Sleep(watchDebounce + 200*time.Millisecond)
+
+	if got := atomic.LoadInt32(&notifications); got != 1 {
+		t.Errorf("got %d OnChange notifications for a coalesced burst of writes, want 1", got)
+	}
+}
+
+func TestWatchAtomicRenameSave(t *testing.T) {
+	dir, fname := writeWatchedFile(t, "value = 1\n")
+
+	conf, _, err := NewFromFiles([]string{filepath.Base(fname)}, []string{dir}, nil)
+	if err != nil {
+		t.Fatalf("NewFromFiles failed: %v", err)
+	}
+
+	changed := make(chan interface{}, 10)
+	conf.OnChange(func(old, new *Conf, changedKeys []string) {
+		changed <- new.Get("value")
+	})
+
+	stop, err := conf.Watch()
+	if err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+	defer stop()
+
+	// Simulate an editor's atomic-rename save: write the new content to a temp file in
+	// the same directory, then rename it over the original. This drops fsnotify's watch
+	// on the original file's inode, so Watch must re-add the watch on the new file to see
+	// any changes after the rename.
+	tmpFname := fname + ".tmp"
+	if err := ioutil.WriteFile(tmpFname, []byte("value = 2\n"), 0644); err != nil {
+		t.Fatalf("ioutil.WriteFile failed: %v", err)
+	}
+	if err := os.Rename(tmpFname, fname); err != nil {
+		t.Fatalf("os.Rename failed: %v", err)
+	}
+
+	if got := waitForChange(t, changed, 3*time.Second); got != int64(2) {
+		t.Fatalf("reloaded value after rename = %v, want 2", got)
+	}
+
+	// A plain write after the rename-based save should still be picked up, proving the
+	// watch was successfully re-added to the new inode.
+	if err := ioutil.WriteFile(fname, []byte("value = 3\n"), 0644); err != nil {
+		t.Fatalf("ioutil.WriteFile failed: %v", err)
+	}
+
+	if got := waitForChange(t, changed, 3*time.Second); got != int64(3) {
+		t.Errorf("reloaded value after post-rename write = %v, want 3", got)
+	}
+}
+
+func TestWatchReloadFailureLeavesConfUnchanged(t *testing.T) {
+	dir, fname := writeWatchedFile(t, "value = 1\n")
+
+	conf, _, err := NewFromFiles([]string{filepath.Base(fname)}, []string{dir}, nil)
+	if err != nil {
+		t.Fatalf("NewFromFiles failed: %v", err)
+	}
+
+	notified := make(chan struct{}, 10)
+	conf.OnChange(func(old, new *Conf, changedKeys []string) {
+		notified <- struct{}{}
+	})
+
+	stop, err := conf.Watch()
+	if err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+	defer stop()
+
+	if err := ioutil.WriteFile(fname, []byte("this is not valid toml === [[["), 0644); err != nil {
+		t.Fatalf("ioutil.WriteFile failed: %v", err)
+	}
+
+	// Give the watch loop time to notice the write, debounce it, and attempt (and fail)
+	// a reload.
+	time.Sleep(watchDebounce + 500*time.Millisecond)
+
+	select {
+	case <-notified:
+		t.Error("OnChange fired despite the reload failing on invalid TOML")
+	default:
+	}
+
+	if got := conf.Get("value"); got != int64(1) {
+		t.Errorf("conf.Get(\"value\") = %v, want 1 (config should survive a failed reload unchanged)", got)
+	}
+}
+
+func TestSetConcurrentWithReloadStaysConsistent(t *testing.T) {
+	dir, fname := writeWatchedFile(t, "value = 1\n")
+
+	conf, _, err := NewFromFiles([]string{filepath.Base(fname)}, []string{dir}, nil)
+	if err != nil {
+		t.Fatalf("NewFromFiles failed: %v", err)
+	}
+
+	stop, err := conf.Watch()
+	if err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+	defer stop()
+
+	// Hammer Set on a key the watched file never touches, concurrently with a stream of
+	// file writes that each trigger a reload. reload's read-diff-install sequence holds
+	// c.mu.Lock() throughout, so a concurrent Set can never interleave with it -- it
+	// either completes entirely before a given reload's critical section or entirely
+	// after. Run this under -race to confirm there's no concurrent access to Tree's
+	// underlying map.
+	const iterations = 200
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 1; i <= iterations; i++ {
+			conf.Set("marker", int64(i))
+		}
+	}()
+
+	for i := 2; i <= iterations+1; i++ {
+		if err := ioutil.WriteFile(fname, []byte(fmt.Sprintf("value = %d\n", i)), 0644); err != nil {
+			t.Fatalf("ioutil.WriteFile failed: %v", err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	<-done
+
+	// Give any reload still in flight from the last few writes time to finish.
+	time.Sleep(watchDebounce + 200*time.Millisecond)
+
+	// The contention above shouldn't leave Watch stuck or c in a torn state: a plain
+	// reload afterwards should still apply cleanly.
+	changed := make(chan interface{}, 1)
+	conf.OnChange(func(old, new *Conf, changedKeys []string) {
+		changed <- new.Get("value")
+	})
+
+	finalValue := int64(iterations + 2)
+	if err := ioutil.WriteFile(fname, []byte(fmt.Sprintf("value = %d\n", finalValue)), 0644); err != nil {
+		t.Fatalf("ioutil.WriteFile failed: %v", err)
+	}
+
+	if got := waitForChange(t, changed, 3*time.Second); got != finalValue {
+		t.Errorf("reloaded value after Set/reload contention = %v, want %d", got, finalValue)
+	}
+}
+
+// writeWatchedFile creates a temp directory containing a single config file with the
+// given contents, registers t.Cleanup to remove the directory, and returns the
+// directory and the full path to the file.
+func writeWatchedFile(t *testing.T, contents string) (dir, fname string) {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "multoml-watch-test")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir failed: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	fname = filepath.Join(dir, "conf.toml")
+	if err := ioutil.WriteFile(fname, []byte(contents), 0644); err != nil {
+		t.Fatalf("ioutil.WriteFile failed: %v", err)
+	}
+
+	return dir, fname
+}
+
+// waitForChange waits up to timeout for a value on ch, failing the test if it times out.
+func waitForChange(t *testing.T, ch chan interface{}, timeout time.Duration) interface{} {
+	t.Helper()
+
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(timeout):
+		t.Fatalf("timed out after %s waiting for an OnChange notification", timeout)
+		return nil
+	}
+}